@@ -18,6 +18,7 @@ package logic
 
 import (
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/labels"
 
 	vpa_types "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1beta2"
@@ -27,67 +28,216 @@ import (
 	"k8s.io/klog"
 )
 
-// ContainerResources holds resources request for container
+// ContainerKind identifies which of a pod's container lists a ContainerResources applies to.
+type ContainerKind int
+
+const (
+	// Container is a regular pod.Spec.Containers entry.
+	Container ContainerKind = iota
+	// InitContainer is a pod.Spec.InitContainers entry.
+	InitContainer
+	// EphemeralContainer is a pod.Spec.EphemeralContainers entry.
+	EphemeralContainer
+)
+
+// String returns the name used for this kind in patch annotations.
+func (k ContainerKind) String() string {
+	switch k {
+	case InitContainer:
+		return "init container"
+	case EphemeralContainer:
+		return "ephemeral container"
+	default:
+		return "container"
+	}
+}
+
+// ContainerResources holds the recommended resources for a single container, identified
+// by its Kind and its Index within that kind's list on the pod. Limits is only populated
+// for resources whose ContainerResourcePolicy.LimitScalingMode calls for a limit patch.
 type ContainerResources struct {
+	Kind     ContainerKind
+	Index    int
 	Requests v1.ResourceList
+	Limits   v1.ResourceList
 }
 
-func newContainerResources() ContainerResources {
-	return ContainerResources{Requests: v1.ResourceList{}}
+// WorkloadKind identifies the kind of the workload object that owns the pod being admitted
+// (Deployment, StatefulSet, DaemonSet, Job, CronJob, Rollout, etc.), as resolved by the
+// controlling VPA's target selector fetcher. It is empty when the owner chain could not be
+// resolved.
+type WorkloadKind string
+
+// Workload kinds the admission server applies kind-specific rules for.
+const (
+	WorkloadKindJob         WorkloadKind = "Job"
+	WorkloadKindDaemonSet   WorkloadKind = "DaemonSet"
+	WorkloadKindStatefulSet WorkloadKind = "StatefulSet"
+)
+
+// RecommendationContext carries the name of the VPA that produced a pod's recommendation
+// together with the kind of workload it targets, so callers can apply kind-specific admission
+// rules without re-resolving the pod's owner chain themselves.
+type RecommendationContext struct {
+	VpaName      string
+	WorkloadKind WorkloadKind
 }
 
-// RecommendationProvider gets current recommendation, annotations and vpaName for the given pod.
+// RecommendationProvider gets current recommendation, annotations and RecommendationContext for the given pod.
 type RecommendationProvider interface {
-	GetContainersResourcesForPod(pod *v1.Pod) ([]ContainerResources, vpa_api_util.ContainerToAnnotationsMap, string, error)
+	GetContainersResourcesForPod(pod *v1.Pod) ([]ContainerResources, vpa_api_util.ContainerToAnnotationsMap, RecommendationContext, error)
 }
 
 type recommendationProvider struct {
 	vpaLister               vpa_lister.VerticalPodAutoscalerLister
 	recommendationProcessor vpa_api_util.RecommendationProcessor
 	selectorFetcher         target.VpaTargetSelectorFetcher
+	// recommenderName is the name this provider serves requests for. VPAs that
+	// list spec.Recommenders must name it to be picked up by this provider; VPAs
+	// that don't specify any recommender fall back to the defaultRecommenderName.
+	recommenderName string
 }
 
 // NewRecommendationProvider constructs the recommendation provider that list VPAs and can be used to determine recommendations for pods.
-func NewRecommendationProvider(vpaLister vpa_lister.VerticalPodAutoscalerLister, recommendationProcessor vpa_api_util.RecommendationProcessor, selectorFetcher target.VpaTargetSelectorFetcher) *recommendationProvider {
+func NewRecommendationProvider(recommenderName string, vpaLister vpa_lister.VerticalPodAutoscalerLister, recommendationProcessor vpa_api_util.RecommendationProcessor, selectorFetcher target.VpaTargetSelectorFetcher) *recommendationProvider {
 	return &recommendationProvider{
 		vpaLister:               vpaLister,
 		recommendationProcessor: recommendationProcessor,
 		selectorFetcher:         selectorFetcher,
+		recommenderName:         recommenderName,
 	}
 }
 
-// getContainersResources returns the recommended resources for each container in the given pod in the same order they are specified in the pod.Spec.
-func getContainersResources(pod *v1.Pod, podRecommendation vpa_types.RecommendedPodResources) []ContainerResources {
-	resources := make([]ContainerResources, len(pod.Spec.Containers))
-	for i, container := range pod.Spec.Containers {
-		resources[i] = newContainerResources()
+// servesVPA reports whether this provider should be considered for vpaConfig, based on
+// the recommender names listed in vpaConfig.Spec.Recommenders.
+func (p *recommendationProvider) servesVPA(vpaConfig *vpa_types.VerticalPodAutoscaler) bool {
+	if len(vpaConfig.Spec.Recommenders) == 0 {
+		return p.recommenderName == defaultRecommenderName
+	}
+	for _, recommender := range vpaConfig.Spec.Recommenders {
+		if recommender == nil {
+			continue
+		}
+		if recommender.Name == p.recommenderName {
+			return true
+		}
+	}
+	return false
+}
+
+// getContainerResources returns the recommended resources for a single container, identified
+// by its kind and index within that kind's list on the pod. original holds the container's
+// current resources, used to compute the Preserve/ProportionalToRequest/MatchRequest limit.
+// For DaemonSet pods, the recommended request is floored at the container's existing request
+// before limits are derived from it, so a ProportionalToRequest/MatchRequest limit is always
+// computed against the request that actually gets applied.
+func getContainerResources(kind ContainerKind, index int, name string, original v1.ResourceRequirements, podRecommendation vpa_types.RecommendedPodResources, resourcePolicy *vpa_types.PodResourcePolicy, workloadKind WorkloadKind) ContainerResources {
+	resources := ContainerResources{Kind: kind, Index: index, Requests: v1.ResourceList{}}
+	recommendation := vpa_api_util.GetRecommendationForContainer(name, &podRecommendation)
+	if recommendation == nil {
+		klog.V(2).Infof("no matching recommendation found for container %s", name)
+		return resources
+	}
+	resources.Requests = recommendation.Target
+	if workloadKind == WorkloadKindDaemonSet {
+		resources.Requests = applyDaemonSetFloor(original, resources.Requests)
+	}
+	resources.Limits = getLimitsForContainer(original, resources.Requests, vpa_api_util.GetContainerResourcePolicy(name, resourcePolicy))
+	return resources
+}
 
-		recommendation := vpa_api_util.GetRecommendationForContainer(container.Name, &podRecommendation)
-		if recommendation == nil {
-			klog.V(2).Infof("no matching recommendation found for container %s", container.Name)
+// applyDaemonSetFloor raises any request in requests that would otherwise shrink below the
+// container's existing request, since scaling a DaemonSet pod down can leave a node unable
+// to run its other workloads once the next recommendation nudges it back up. It runs before
+// limits are derived from the request so a ProportionalToRequest/MatchRequest limit is never
+// computed against a request smaller than the one actually applied.
+func applyDaemonSetFloor(existing v1.ResourceRequirements, requests v1.ResourceList) v1.ResourceList {
+	if len(requests) == 0 {
+		return requests
+	}
+	floored := v1.ResourceList{}
+	for resourceName, newRequest := range requests {
+		if existingRequest, ok := existing.Requests[resourceName]; ok && existingRequest.Cmp(newRequest) > 0 {
+			floored[resourceName] = existingRequest
+			continue
+		}
+		floored[resourceName] = newRequest
+	}
+	return floored
+}
+
+// getLimitsForContainer computes the new resource limits for a container given its current
+// resources, its newly recommended requests and its configured LimitScalingMode. It returns
+// nil (no limit patches) for ContainerResourcePolicy.LimitScalingModePreserve, the implicit
+// default, and for any resource that didn't already have a limit set.
+func getLimitsForContainer(original v1.ResourceRequirements, newRequests v1.ResourceList, policy *vpa_types.ContainerResourcePolicy) v1.ResourceList {
+	if policy == nil || policy.LimitScalingMode == nil || *policy.LimitScalingMode == vpa_types.LimitScalingModePreserve {
+		return nil
+	}
+	limits := v1.ResourceList{}
+	for resourceName, newRequest := range newRequests {
+		originalLimit, hasLimit := original.Limits[resourceName]
+		if !hasLimit {
 			continue
 		}
-		resources[i].Requests = recommendation.Target
+		switch *policy.LimitScalingMode {
+		case vpa_types.LimitScalingModeMatchRequest:
+			limits[resourceName] = newRequest
+		case vpa_types.LimitScalingModeProportionalToRequest:
+			originalRequest, hasRequest := original.Requests[resourceName]
+			if !hasRequest || originalRequest.MilliValue() == 0 {
+				klog.V(2).Infof("cannot scale %s limit proportionally to request: no original request", resourceName)
+				continue
+			}
+			ratio := float64(newRequest.MilliValue()) / float64(originalRequest.MilliValue())
+			limits[resourceName] = *resource.NewMilliQuantity(int64(float64(originalLimit.MilliValue())*ratio), originalLimit.Format)
+		}
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+	return limits
+}
+
+// getContainersResources returns the recommended resources for every container, init container
+// and ephemeral container in the given pod, in that order.
+func getContainersResources(pod *v1.Pod, podRecommendation vpa_types.RecommendedPodResources, resourcePolicy *vpa_types.PodResourcePolicy, workloadKind WorkloadKind) []ContainerResources {
+	resources := make([]ContainerResources, 0, len(pod.Spec.Containers)+len(pod.Spec.InitContainers)+len(pod.Spec.EphemeralContainers))
+	for i, container := range pod.Spec.Containers {
+		resources = append(resources, getContainerResources(Container, i, container.Name, container.Resources, podRecommendation, resourcePolicy, workloadKind))
+	}
+	for i, container := range pod.Spec.InitContainers {
+		resources = append(resources, getContainerResources(InitContainer, i, container.Name, container.Resources, podRecommendation, resourcePolicy, workloadKind))
+	}
+	for i, container := range pod.Spec.EphemeralContainers {
+		resources = append(resources, getContainerResources(EphemeralContainer, i, container.Name, container.Resources, podRecommendation, resourcePolicy, workloadKind))
 	}
 	return resources
 }
 
-func (p *recommendationProvider) getMatchingVPA(pod *v1.Pod) *vpa_types.VerticalPodAutoscaler {
+// getMatchingVPA returns the VPA controlling pod, along with the kind of workload the VPA
+// targets as resolved by the selector fetcher (e.g. "StatefulSet", "DaemonSet").
+func (p *recommendationProvider) getMatchingVPA(pod *v1.Pod) (*vpa_types.VerticalPodAutoscaler, WorkloadKind) {
 	configs, err := p.vpaLister.VerticalPodAutoscalers(pod.Namespace).List(labels.Everything())
 	if err != nil {
 		klog.Errorf("failed to get vpa configs: %v", err)
-		return nil
+		return nil, ""
 	}
 	onConfigs := make([]*vpa_api_util.VpaWithSelector, 0)
+	workloadKindByVpaName := make(map[string]WorkloadKind)
 	for _, vpaConfig := range configs {
 		if vpa_api_util.GetUpdateMode(vpaConfig) == vpa_types.UpdateModeOff {
 			continue
 		}
-		selector, err := p.selectorFetcher.Fetch(vpaConfig)
+		if !p.servesVPA(vpaConfig) {
+			continue
+		}
+		selector, workloadKind, err := p.selectorFetcher.Fetch(vpaConfig)
 		if err != nil {
 			klog.V(3).Infof("skipping VPA object %v because we cannot fetch selector", vpaConfig.Name)
 			continue
 		}
+		workloadKindByVpaName[vpaConfig.Name] = WorkloadKind(workloadKind)
 		onConfigs = append(onConfigs, &vpa_api_util.VpaWithSelector{
 			Vpa:      vpaConfig,
 			Selector: selector,
@@ -96,20 +246,23 @@ func (p *recommendationProvider) getMatchingVPA(pod *v1.Pod) *vpa_types.Vertical
 	klog.V(2).Infof("Let's choose from %d configs for pod %s/%s", len(onConfigs), pod.Namespace, pod.Name)
 	result := vpa_api_util.GetControllingVPAForPod(pod, onConfigs)
 	if result != nil {
-		return result.Vpa
+		return result.Vpa, workloadKindByVpaName[result.Vpa.Name]
 	}
-	return nil
+	return nil, ""
 }
 
-// GetContainersResourcesForPod returns recommended request for a given pod, annotations and name of controlling VPA.
-// The returned slice corresponds 1-1 to containers in the Pod.
-func (p *recommendationProvider) GetContainersResourcesForPod(pod *v1.Pod) ([]ContainerResources, vpa_api_util.ContainerToAnnotationsMap, string, error) {
+// GetContainersResourcesForPod returns recommended request for a given pod, annotations and the
+// RecommendationContext (controlling VPA name and target workload kind) for the pod.
+// The returned slice holds one entry per container, init container and ephemeral container in the Pod,
+// in that order; use ContainerResources.Kind and ContainerResources.Index to locate each in the Pod spec.
+func (p *recommendationProvider) GetContainersResourcesForPod(pod *v1.Pod) ([]ContainerResources, vpa_api_util.ContainerToAnnotationsMap, RecommendationContext, error) {
 	klog.V(2).Infof("updating requirements for pod %s.", pod.Name)
-	vpaConfig := p.getMatchingVPA(pod)
+	vpaConfig, workloadKind := p.getMatchingVPA(pod)
 	if vpaConfig == nil {
 		klog.V(2).Infof("no matching VPA found for pod %s", pod.Name)
-		return nil, nil, "", nil
+		return nil, nil, RecommendationContext{}, nil
 	}
+	recommendationContext := RecommendationContext{VpaName: vpaConfig.Name, WorkloadKind: workloadKind}
 
 	var annotations vpa_api_util.ContainerToAnnotationsMap
 	recommendedPodResources := &vpa_types.RecommendedPodResources{}
@@ -119,9 +272,9 @@ func (p *recommendationProvider) GetContainersResourcesForPod(pod *v1.Pod) ([]Co
 		recommendedPodResources, annotations, err = p.recommendationProcessor.Apply(vpaConfig.Status.Recommendation, vpaConfig.Spec.ResourcePolicy, vpaConfig.Status.Conditions, pod)
 		if err != nil {
 			klog.V(2).Infof("cannot process recommendation for pod %s", pod.Name)
-			return nil, annotations, vpaConfig.Name, err
+			return nil, annotations, recommendationContext, err
 		}
 	}
-	containerResources := getContainersResources(pod, *recommendedPodResources)
-	return containerResources, annotations, vpaConfig.Name, nil
+	containerResources := getContainersResources(pod, *recommendedPodResources, vpaConfig.Spec.ResourcePolicy, workloadKind)
+	return containerResources, annotations, recommendationContext, nil
 }
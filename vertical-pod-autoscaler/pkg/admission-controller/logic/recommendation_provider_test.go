@@ -0,0 +1,267 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logic
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	vpa_types "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1beta2"
+	vpa_lister "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/listers/autoscaling.k8s.io/v1beta2"
+	vpa_api_util "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/utils/vpa"
+)
+
+func limitScalingMode(mode vpa_types.LimitScalingMode) *vpa_types.LimitScalingMode {
+	return &mode
+}
+
+func TestGetLimitsForContainer(t *testing.T) {
+	tests := []struct {
+		name         string
+		original     apiv1.ResourceRequirements
+		newRequests  apiv1.ResourceList
+		policy       *vpa_types.ContainerResourcePolicy
+		expectLimits apiv1.ResourceList
+	}{
+		{
+			name: "no policy: no limit patch",
+			original: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{cpu: resource.MustParse("1")},
+				Limits:   apiv1.ResourceList{cpu: resource.MustParse("2")},
+			},
+			newRequests: apiv1.ResourceList{cpu: resource.MustParse("2")},
+			policy:      nil,
+		},
+		{
+			name: "Preserve: no limit patch",
+			original: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{cpu: resource.MustParse("1")},
+				Limits:   apiv1.ResourceList{cpu: resource.MustParse("2")},
+			},
+			newRequests: apiv1.ResourceList{cpu: resource.MustParse("2")},
+			policy:      &vpa_types.ContainerResourcePolicy{LimitScalingMode: limitScalingMode(vpa_types.LimitScalingModePreserve)},
+		},
+		{
+			name: "MatchRequest",
+			original: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{cpu: resource.MustParse("1")},
+				Limits:   apiv1.ResourceList{cpu: resource.MustParse("2")},
+			},
+			newRequests: apiv1.ResourceList{cpu: resource.MustParse("3")},
+			policy:      &vpa_types.ContainerResourcePolicy{LimitScalingMode: limitScalingMode(vpa_types.LimitScalingModeMatchRequest)},
+			expectLimits: apiv1.ResourceList{
+				cpu: resource.MustParse("3"),
+			},
+		},
+		{
+			name: "ProportionalToRequest doubles the limit when the request doubles",
+			original: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{cpu: resource.MustParse("1")},
+				Limits:   apiv1.ResourceList{cpu: resource.MustParse("2")},
+			},
+			newRequests: apiv1.ResourceList{cpu: resource.MustParse("2")},
+			policy:      &vpa_types.ContainerResourcePolicy{LimitScalingMode: limitScalingMode(vpa_types.LimitScalingModeProportionalToRequest)},
+			expectLimits: apiv1.ResourceList{
+				cpu: resource.MustParse("4"),
+			},
+		},
+		{
+			name: "ProportionalToRequest: no original request, no limit patch",
+			original: apiv1.ResourceRequirements{
+				Limits: apiv1.ResourceList{cpu: resource.MustParse("2")},
+			},
+			newRequests: apiv1.ResourceList{cpu: resource.MustParse("2")},
+			policy:      &vpa_types.ContainerResourcePolicy{LimitScalingMode: limitScalingMode(vpa_types.LimitScalingModeProportionalToRequest)},
+		},
+		{
+			name: "MatchRequest: no original limit for the resource, no limit patch",
+			original: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{cpu: resource.MustParse("1")},
+			},
+			newRequests: apiv1.ResourceList{cpu: resource.MustParse("2")},
+			policy:      &vpa_types.ContainerResourcePolicy{LimitScalingMode: limitScalingMode(vpa_types.LimitScalingModeMatchRequest)},
+		},
+		{
+			name: "MatchRequest: cpu and memory scaled independently",
+			original: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{
+					cpu:                  resource.MustParse("1"),
+					apiv1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+				Limits: apiv1.ResourceList{
+					apiv1.ResourceMemory: resource.MustParse("2Gi"),
+				},
+			},
+			newRequests: apiv1.ResourceList{
+				cpu:                  resource.MustParse("2"),
+				apiv1.ResourceMemory: resource.MustParse("2Gi"),
+			},
+			policy: &vpa_types.ContainerResourcePolicy{LimitScalingMode: limitScalingMode(vpa_types.LimitScalingModeMatchRequest)},
+			expectLimits: apiv1.ResourceList{
+				apiv1.ResourceMemory: resource.MustParse("2Gi"),
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			limits := getLimitsForContainer(tc.original, tc.newRequests, tc.policy)
+			assertResourceListsEqual(t, tc.expectLimits, limits)
+		})
+	}
+}
+
+// assertResourceListsEqual compares ResourceLists by value, since resource.Quantity values
+// computed via arithmetic (e.g. resource.NewMilliQuantity) aren't DeepEqual to an
+// equivalent resource.MustParse literal even when they represent the same quantity.
+func assertResourceListsEqual(t *testing.T, expected, actual apiv1.ResourceList) {
+	t.Helper()
+	if !assert.Equal(t, len(expected), len(actual)) {
+		return
+	}
+	for resourceName, expectedQuantity := range expected {
+		actualQuantity, found := actual[resourceName]
+		if !assert.True(t, found, "missing resource %s", resourceName) {
+			continue
+		}
+		assert.Zero(t, expectedQuantity.Cmp(actualQuantity), "resource %s: expected %s, got %s", resourceName, expectedQuantity.String(), actualQuantity.String())
+	}
+}
+
+// fakeVpaLister serves a fixed list of VPAs, mirroring the generated lister interface closely
+// enough to exercise getMatchingVPA without a fake Kubernetes API server.
+type fakeVpaLister struct {
+	vpas []*vpa_types.VerticalPodAutoscaler
+}
+
+func (l *fakeVpaLister) List(selector labels.Selector) ([]*vpa_types.VerticalPodAutoscaler, error) {
+	return l.vpas, nil
+}
+
+func (l *fakeVpaLister) VerticalPodAutoscalers(namespace string) vpa_lister.VerticalPodAutoscalerNamespaceLister {
+	return l
+}
+
+func (l *fakeVpaLister) Get(name string) (*vpa_types.VerticalPodAutoscaler, error) {
+	for _, vpa := range l.vpas {
+		if vpa.Name == name {
+			return vpa, nil
+		}
+	}
+	return nil, fmt.Errorf("vpa %s not found", name)
+}
+
+// fakeSelectorFetcher resolves every VPA's selector to labels.Everything(), and its workload
+// kind to whatever was configured for that VPA's name, so tests can plumb a fake StatefulSet
+// or DaemonSet owner through getMatchingVPA without a real target.VpaTargetSelectorFetcher.
+type fakeSelectorFetcher struct {
+	workloadKindByVpaName map[string]string
+}
+
+func (f *fakeSelectorFetcher) Fetch(vpa *vpa_types.VerticalPodAutoscaler) (labels.Selector, string, error) {
+	return labels.Everything(), f.workloadKindByVpaName[vpa.Name], nil
+}
+
+// fakeRecommendationProcessor passes the recommendation through unmodified, since these tests
+// only care about the VPA and workload kind getMatchingVPA resolves for a pod.
+type fakeRecommendationProcessor struct{}
+
+func (fakeRecommendationProcessor) Apply(podRecommendation *vpa_types.RecommendedPodResources, policy *vpa_types.PodResourcePolicy, conditions []vpa_types.VerticalPodAutoscalerCondition, pod *apiv1.Pod) (*vpa_types.RecommendedPodResources, vpa_api_util.ContainerToAnnotationsMap, error) {
+	return podRecommendation, nil, nil
+}
+
+func TestGetMatchingVPAResolvesWorkloadKind(t *testing.T) {
+	tests := []struct {
+		name               string
+		workloadKind       string
+		expectWorkloadKind WorkloadKind
+	}{
+		{name: "StatefulSet owner", workloadKind: "StatefulSet", expectWorkloadKind: WorkloadKindStatefulSet},
+		{name: "DaemonSet owner", workloadKind: "DaemonSet", expectWorkloadKind: WorkloadKindDaemonSet},
+		{name: "Job owner", workloadKind: "Job", expectWorkloadKind: WorkloadKindJob},
+		{name: "unresolved owner", workloadKind: "", expectWorkloadKind: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vpa := &vpa_types.VerticalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "vpa-1", Namespace: "default"}}
+			pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}}
+			p := &recommendationProvider{
+				vpaLister:               &fakeVpaLister{vpas: []*vpa_types.VerticalPodAutoscaler{vpa}},
+				recommendationProcessor: fakeRecommendationProcessor{},
+				selectorFetcher:         &fakeSelectorFetcher{workloadKindByVpaName: map[string]string{"vpa-1": tc.workloadKind}},
+				recommenderName:         defaultRecommenderName,
+			}
+
+			matched, workloadKind := p.getMatchingVPA(pod)
+
+			if assert.NotNil(t, matched) {
+				assert.Equal(t, "vpa-1", matched.Name)
+			}
+			assert.Equal(t, tc.expectWorkloadKind, workloadKind)
+		})
+	}
+}
+
+// TestGetContainerResourcesFloorsDaemonSetRequestBeforeDerivingLimits guards against computing a
+// ProportionalToRequest/MatchRequest limit against a request that shrank below the container's
+// existing request, only for the DaemonSet floor to raise that request back up afterwards: the
+// emitted limit must be derived from the floored request, never from the smaller one it replaced.
+func TestGetContainerResourcesFloorsDaemonSetRequestBeforeDerivingLimits(t *testing.T) {
+	original := apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{cpu: resource.MustParse("2")},
+		Limits:   apiv1.ResourceList{cpu: resource.MustParse("4")},
+	}
+	podRecommendation := vpa_types.RecommendedPodResources{
+		ContainerRecommendations: []vpa_types.RecommendedContainerResources{
+			{ContainerName: "test", Target: apiv1.ResourceList{cpu: resource.MustParse("1")}},
+		},
+	}
+	policy := &vpa_types.PodResourcePolicy{
+		ContainerPolicies: []vpa_types.ContainerResourcePolicy{
+			{ContainerName: "test", LimitScalingMode: limitScalingMode(vpa_types.LimitScalingModeProportionalToRequest)},
+		},
+	}
+
+	resources := getContainerResources(Container, 0, "test", original, podRecommendation, policy, WorkloadKindDaemonSet)
+
+	assertResourceListsEqual(t, apiv1.ResourceList{cpu: resource.MustParse("2")}, resources.Requests)
+	assertResourceListsEqual(t, apiv1.ResourceList{cpu: resource.MustParse("4")}, resources.Limits)
+}
+
+// TestServesVPASkipsNullRecommenderEntries guards against a panic on a VPA whose
+// spec.Recommenders contains a null entry (e.g. submitted as {"recommenders":[null]}), which
+// decodes to a nil *VerticalPodAutoscalerRecommenderSelector in that slot. servesVPA is called
+// once per configured recommendation provider on every pod admission, so a panic here is a
+// cluster-wide pod-admission outage under failurePolicy: Fail.
+func TestServesVPASkipsNullRecommenderEntries(t *testing.T) {
+	p := &recommendationProvider{recommenderName: defaultRecommenderName}
+	vpa := &vpa_types.VerticalPodAutoscaler{
+		Spec: vpa_types.VerticalPodAutoscalerSpec{
+			Recommenders: []*vpa_types.VerticalPodAutoscalerRecommenderSelector{nil},
+		},
+	}
+
+	assert.NotPanics(t, func() {
+		assert.False(t, p.servesVPA(vpa))
+	})
+}
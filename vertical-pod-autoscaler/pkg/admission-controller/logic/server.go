@@ -0,0 +1,311 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	vpa_types "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1beta2"
+	vpa_api_util "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/utils/vpa"
+	"k8s.io/klog"
+)
+
+// defaultRecommenderName is the key used to look up the RecommendationProvider
+// for VPA objects that do not specify spec.Recommenders, on clusters that only
+// have a single recommender configured.
+const defaultRecommenderName = "default"
+
+// PodPreProcessor - interface to pre-process pod before admission
+type PodPreProcessor interface {
+	Process(pod v1.Pod) (v1.Pod, error)
+}
+
+// VpaPreProcessor - interface to pre-process VPA before admission
+type VpaPreProcessor interface {
+	Process(vpa *vpa_types.VerticalPodAutoscaler, isCreate bool) (*vpa_types.VerticalPodAutoscaler, error)
+}
+
+// AdmissionServer is an admission webhook server that modifies pod resources request based on VPA recommendation
+type AdmissionServer struct {
+	recommendationProviders map[string]RecommendationProvider
+	podPreProcessor         PodPreProcessor
+	vpaPreProcessor         VpaPreProcessor
+}
+
+// NewAdmissionServer constructs new AdmissionServer. recommendationProviders maps
+// recommender name (as referenced from a VPA's spec.Recommenders) to the
+// RecommendationProvider serving it.
+func NewAdmissionServer(recommendationProviders map[string]RecommendationProvider, podPreProcessor PodPreProcessor, vpaPreProcessor VpaPreProcessor) *AdmissionServer {
+	return &AdmissionServer{
+		recommendationProviders: recommendationProviders,
+		podPreProcessor:         podPreProcessor,
+		vpaPreProcessor:         vpaPreProcessor,
+	}
+}
+
+type patchRecord struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// containerResourcesPath returns the JSON-patch base path for the given container kind and
+// index, e.g. "/spec/initContainers/0".
+func containerResourcesPath(kind ContainerKind, index int) string {
+	switch kind {
+	case InitContainer:
+		return fmt.Sprintf("/spec/initContainers/%d", index)
+	case EphemeralContainer:
+		return fmt.Sprintf("/spec/ephemeralContainers/%d", index)
+	default:
+		return fmt.Sprintf("/spec/containers/%d", index)
+	}
+}
+
+// containerSpec returns the name and existing resources of the container identified by kind
+// and index, so the patch generator can tell whether it needs to initialize the
+// resources/requests or resources/limits object before adding individual resource entries.
+func containerSpec(pod v1.Pod, kind ContainerKind, index int) (name string, resources v1.ResourceRequirements) {
+	switch kind {
+	case InitContainer:
+		c := pod.Spec.InitContainers[index]
+		return c.Name, c.Resources
+	case EphemeralContainer:
+		c := pod.Spec.EphemeralContainers[index]
+		return c.Name, c.Resources
+	default:
+		c := pod.Spec.Containers[index]
+		return c.Name, c.Resources
+	}
+}
+
+func getPatchInitializingEmptyResourcesObject(basePath string) patchRecord {
+	return patchRecord{
+		Op:    "add",
+		Path:  basePath + "/resources",
+		Value: v1.ResourceRequirements{},
+	}
+}
+
+func getPatchInitializingEmptyResourceList(basePath, field string) patchRecord {
+	return patchRecord{
+		Op:    "add",
+		Path:  fmt.Sprintf("%s/resources/%s", basePath, field),
+		Value: v1.ResourceList{},
+	}
+}
+
+func getAddResourcePatch(basePath, field string, resourceName v1.ResourceName, quantity resource.Quantity) patchRecord {
+	return patchRecord{
+		Op:    "add",
+		Path:  fmt.Sprintf("%s/resources/%s/%s", basePath, field, resourceName),
+		Value: quantity,
+	}
+}
+
+// getContainerPatch returns the patches needed to apply containerResources (new requests and,
+// where the container's limit scaling policy calls for it, new limits) to its container in pod,
+// plus a human readable summary of the applied updates (empty if none). containerResources.Requests
+// is expected to already reflect any workload-kind-specific adjustment (e.g. the DaemonSet floor
+// applied in recommendation_provider.go), since its Limits were derived from that same request.
+func getContainerPatch(pod v1.Pod, annotationsPerContainer vpa_api_util.ContainerToAnnotationsMap, containerResources ContainerResources) ([]patchRecord, string) {
+	basePath := containerResourcesPath(containerResources.Kind, containerResources.Index)
+	name, existing := containerSpec(pod, containerResources.Kind, containerResources.Index)
+
+	var patches []patchRecord
+	if existing.Requests == nil && existing.Limits == nil {
+		patches = append(patches, getPatchInitializingEmptyResourcesObject(basePath))
+	}
+	if existing.Requests == nil && len(containerResources.Requests) > 0 {
+		patches = append(patches, getPatchInitializingEmptyResourceList(basePath, "requests"))
+	}
+	if existing.Limits == nil && len(containerResources.Limits) > 0 {
+		patches = append(patches, getPatchInitializingEmptyResourceList(basePath, "limits"))
+	}
+
+	annotations := annotationsPerContainer[name]
+	for resourceName, quantity := range containerResources.Requests {
+		patches = append(patches, getAddResourcePatch(basePath, "requests", resourceName, quantity))
+		annotations = append(annotations, fmt.Sprintf("%s request", resourceName))
+	}
+	for resourceName, quantity := range containerResources.Limits {
+		patches = append(patches, getAddResourcePatch(basePath, "limits", resourceName, quantity))
+		annotations = append(annotations, fmt.Sprintf("%s limit", resourceName))
+	}
+	if len(annotations) == 0 {
+		return patches, ""
+	}
+	return patches, fmt.Sprintf("%s %d: %s", containerResources.Kind, containerResources.Index, strings.Join(annotations, ", "))
+}
+
+// recommendationProviderFor returns the RecommendationProvider that should be used for pod,
+// by trying every configured provider and returning the first one that reports a controlling VPA.
+// Providers are tried in deterministic, sorted-by-name order so that a VPA matched by more than
+// one named recommender always gets the same provider's policy, rather than one that varies with
+// Go's unordered map iteration.
+func (s *AdmissionServer) recommendationProviderFor(pod *v1.Pod) ([]ContainerResources, vpa_api_util.ContainerToAnnotationsMap, RecommendationContext, error) {
+	names := make([]string, 0, len(s.recommendationProviders))
+	for name := range s.recommendationProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		containerResources, annotations, recommendationContext, err := s.recommendationProviders[name].GetContainersResourcesForPod(pod)
+		if err != nil {
+			return nil, nil, RecommendationContext{}, err
+		}
+		if recommendationContext.VpaName != "" {
+			return containerResources, annotations, recommendationContext, nil
+		}
+	}
+	return nil, nil, RecommendationContext{}, nil
+}
+
+// podAlreadyRanToCompletion reports whether pod has finished running, i.e. patching its
+// resources in place could no longer have any effect.
+func podAlreadyRanToCompletion(pod v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+func (s *AdmissionServer) getPatchesForPodResourceRequest(raw []byte, namespace string) ([]patchRecord, error) {
+	pod := v1.Pod{}
+	if err := json.Unmarshal(raw, &pod); err != nil {
+		return nil, err
+	}
+	if len(pod.Namespace) == 0 {
+		pod.Namespace = namespace
+	}
+	klog.V(4).Infof("Admitting pod %v", pod.ObjectMeta)
+
+	pod, err := s.podPreProcessor.Process(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	containersResources, annotationsPerContainer, recommendationContext, err := s.recommendationProviderFor(&pod)
+	if err != nil {
+		return nil, err
+	}
+	if annotationsPerContainer == nil {
+		annotationsPerContainer = vpa_api_util.ContainerToAnnotationsMap{}
+	}
+	if recommendationContext.WorkloadKind == WorkloadKindJob && podAlreadyRanToCompletion(pod) {
+		klog.V(4).Infof("Not updating pod %s/%s, its owning Job has already run to completion", pod.Namespace, pod.Name)
+		return nil, nil
+	}
+
+	patches := []patchRecord{}
+	updatesAnnotation := []string{}
+	for _, containerResources := range containersResources {
+		newPatches, newUpdateAnnotation := getContainerPatch(pod, annotationsPerContainer, containerResources)
+		patches = append(patches, newPatches...)
+		if newUpdateAnnotation != "" {
+			updatesAnnotation = append(updatesAnnotation, newUpdateAnnotation)
+		}
+	}
+
+	if len(updatesAnnotation) > 0 {
+		updateVerb := "Pod resources updated by"
+		if recommendationContext.WorkloadKind == WorkloadKindStatefulSet {
+			updateVerb = "StatefulSet member pod resources updated by"
+		}
+		vpaAnnotationValue := fmt.Sprintf("%s %s: %s", updateVerb, recommendationContext.VpaName, strings.Join(updatesAnnotation, "; "))
+		patches = append(patches, patchRecord{
+			Op:   "add",
+			Path: "/metadata/annotations",
+			Value: map[string]string{
+				"vpaUpdates": vpaAnnotationValue,
+			},
+		})
+	} else {
+		klog.V(4).Infof("Not updating pod %s/%s, resources were unchanged", pod.Namespace, pod.Name)
+	}
+	return patches, nil
+}
+
+// validateVPA checks the correctness of the VPA spec, including the recommenders it references.
+func (s *AdmissionServer) validateVPA(vpa *vpa_types.VerticalPodAutoscaler, isCreate bool) error {
+	if vpa.Spec.UpdatePolicy != nil {
+		mode := vpa.Spec.UpdatePolicy.UpdateMode
+		if mode == nil {
+			return fmt.Errorf("UpdateMode is required if UpdatePolicy is used")
+		}
+		if *mode != vpa_types.UpdateModeOff && *mode != vpa_types.UpdateModeInitial &&
+			*mode != vpa_types.UpdateModeRecreate && *mode != vpa_types.UpdateModeAuto {
+			return fmt.Errorf("unexpected UpdateMode value %s", *mode)
+		}
+	}
+
+	if vpa.Spec.ResourcePolicy != nil {
+		for _, policy := range vpa.Spec.ResourcePolicy.ContainerPolicies {
+			if policy.ContainerName == "" {
+				return fmt.Errorf("ContainerPolicies.ContainerName is required")
+			}
+			mode := policy.Mode
+			if mode != nil && *mode != vpa_types.ContainerScalingModeAuto && *mode != vpa_types.ContainerScalingModeOff {
+				return fmt.Errorf("unexpected Mode value %s", *mode)
+			}
+			limitScalingMode := policy.LimitScalingMode
+			if limitScalingMode != nil && *limitScalingMode != vpa_types.LimitScalingModePreserve &&
+				*limitScalingMode != vpa_types.LimitScalingModeProportionalToRequest && *limitScalingMode != vpa_types.LimitScalingModeMatchRequest {
+				return fmt.Errorf("unexpected LimitScalingMode value %s", *limitScalingMode)
+			}
+			for resourceName, min := range policy.MinAllowed {
+				max, found := policy.MaxAllowed[resourceName]
+				if found && max.Cmp(min) < 0 {
+					return fmt.Errorf("max resource for %s is lower than min", resourceName)
+				}
+			}
+		}
+	}
+
+	if err := s.validateVPARecommenders(vpa); err != nil {
+		return err
+	}
+
+	if isCreate && vpa.Spec.TargetRef == nil {
+		return fmt.Errorf("TargetRef is required. If you're using v1beta1 version of the API, please migrate to v1beta2.")
+	}
+	return nil
+}
+
+// validateVPARecommenders rejects VPAs that name more than one recommender (only one recommender
+// per VPA object is supported, see VerticalPodAutoscalerSpec.Recommenders), or that name a
+// recommender the operator isn't running.
+func (s *AdmissionServer) validateVPARecommenders(vpa *vpa_types.VerticalPodAutoscaler) error {
+	if len(vpa.Spec.Recommenders) == 0 {
+		return nil
+	}
+	if len(vpa.Spec.Recommenders) > 1 {
+		return fmt.Errorf("VPA %s specifies %d recommenders but only one recommender per VPA object is supported", vpa.Name, len(vpa.Spec.Recommenders))
+	}
+	for _, recommender := range vpa.Spec.Recommenders {
+		if recommender == nil {
+			continue
+		}
+		if _, ok := s.recommendationProviders[recommender.Name]; !ok {
+			return fmt.Errorf("unknown recommender name %s", recommender.Name)
+		}
+	}
+	return nil
+}
@@ -54,11 +54,12 @@ type fakeRecommendationProvider struct {
 	resources              []ContainerResources
 	containerToAnnotations vpa_api_util.ContainerToAnnotationsMap
 	name                   string
+	workloadKind           WorkloadKind
 	e                      error
 }
 
-func (frp *fakeRecommendationProvider) GetContainersResourcesForPod(pod *apiv1.Pod) ([]ContainerResources, vpa_api_util.ContainerToAnnotationsMap, string, error) {
-	return frp.resources, frp.containerToAnnotations, frp.name, frp.e
+func (frp *fakeRecommendationProvider) GetContainersResourcesForPod(pod *apiv1.Pod) ([]ContainerResources, vpa_api_util.ContainerToAnnotationsMap, RecommendationContext, error) {
+	return frp.resources, frp.containerToAnnotations, RecommendationContext{VpaName: frp.name, WorkloadKind: frp.workloadKind}, frp.e
 }
 
 func addResourcesPatch(idx int) patchRecord {
@@ -113,16 +114,17 @@ func eqPatch(a, b patchRecord) bool {
 
 func TestGetPatchesForResourceRequest(t *testing.T) {
 	tests := []struct {
-		name                 string
-		podJson              []byte
-		namespace            string
-		podPreProcessorError error
-		recommendResources   []ContainerResources
-		recommendAnnotations vpa_api_util.ContainerToAnnotationsMap
-		recommendName        string
-		recommendError       error
-		expectPatches        []patchRecord
-		expectError          error
+		name                  string
+		podJson               []byte
+		namespace             string
+		podPreProcessorError  error
+		recommendResources    []ContainerResources
+		recommendAnnotations  vpa_api_util.ContainerToAnnotationsMap
+		recommendName         string
+		recommendWorkloadKind WorkloadKind
+		recommendError        error
+		expectPatches         []patchRecord
+		expectError           error
 	}{
 		{
 			name:                 "invalid JSON",
@@ -155,7 +157,8 @@ func TestGetPatchesForResourceRequest(t *testing.T) {
 			namespace: "default",
 			recommendResources: []ContainerResources{
 				{
-					apiv1.ResourceList{
+					Index: 0,
+					Requests: apiv1.ResourceList{
 						cpu: resource.MustParse("1"),
 					},
 				},
@@ -188,7 +191,8 @@ func TestGetPatchesForResourceRequest(t *testing.T) {
 			namespace: "default",
 			recommendResources: []ContainerResources{
 				{
-					apiv1.ResourceList{
+					Index: 0,
+					Requests: apiv1.ResourceList{
 						cpu: resource.MustParse("1"),
 					},
 				},
@@ -220,12 +224,14 @@ func TestGetPatchesForResourceRequest(t *testing.T) {
 			namespace: "default",
 			recommendResources: []ContainerResources{
 				{
-					apiv1.ResourceList{
+					Index: 0,
+					Requests: apiv1.ResourceList{
 						cpu: resource.MustParse("1"),
 					},
 				},
 				{
-					apiv1.ResourceList{
+					Index: 1,
+					Requests: apiv1.ResourceList{
 						cpu: resource.MustParse("2"),
 					},
 				},
@@ -240,13 +246,241 @@ func TestGetPatchesForResourceRequest(t *testing.T) {
 				addAnnotationRequest([][]string{{cpu}, {cpu}}),
 			},
 		},
+		{
+			name: "init container recommendation",
+			podJson: []byte(
+				`{
+					"spec": {
+						"containers": [{}],
+						"initContainers": [{}]
+					}
+				}`),
+			namespace: "default",
+			recommendResources: []ContainerResources{
+				{
+					Kind:  Container,
+					Index: 0,
+					Requests: apiv1.ResourceList{
+						cpu: resource.MustParse("1"),
+					},
+				},
+				{
+					Kind:  InitContainer,
+					Index: 0,
+					Requests: apiv1.ResourceList{
+						cpu: resource.MustParse("2"),
+					},
+				},
+			},
+			recommendAnnotations: vpa_api_util.ContainerToAnnotationsMap{},
+			recommendName:        "name",
+			expectPatches: []patchRecord{
+				addResourcesPatch(0),
+				addRequestsPatch(0),
+				addResourceRequestPatch(0, cpu, "1"),
+				{"add", "/spec/initContainers/0/resources", apiv1.ResourceRequirements{}},
+				{"add", "/spec/initContainers/0/resources/requests", apiv1.ResourceList{}},
+				{"add", "/spec/initContainers/0/resources/requests/cpu", resource.MustParse("2")},
+				{
+					"add",
+					"/metadata/annotations",
+					map[string]string{
+						"vpaUpdates": "Pod resources updated by name: container 0: cpu request; init container 0: cpu request",
+					},
+				},
+			},
+		},
+		{
+			name: "ephemeral container recommendation",
+			podJson: []byte(
+				`{
+					"spec": {
+						"containers": [{}],
+						"ephemeralContainers": [{}]
+					}
+				}`),
+			namespace: "default",
+			recommendResources: []ContainerResources{
+				{
+					Kind:  Container,
+					Index: 0,
+					Requests: apiv1.ResourceList{
+						cpu: resource.MustParse("1"),
+					},
+				},
+				{
+					Kind:  EphemeralContainer,
+					Index: 0,
+					Requests: apiv1.ResourceList{
+						cpu: resource.MustParse("2"),
+					},
+				},
+			},
+			recommendAnnotations: vpa_api_util.ContainerToAnnotationsMap{},
+			recommendName:        "name",
+			expectPatches: []patchRecord{
+				addResourcesPatch(0),
+				addRequestsPatch(0),
+				addResourceRequestPatch(0, cpu, "1"),
+				{"add", "/spec/ephemeralContainers/0/resources", apiv1.ResourceRequirements{}},
+				{"add", "/spec/ephemeralContainers/0/resources/requests", apiv1.ResourceList{}},
+				{"add", "/spec/ephemeralContainers/0/resources/requests/cpu", resource.MustParse("2")},
+				{
+					"add",
+					"/metadata/annotations",
+					map[string]string{
+						"vpaUpdates": "Pod resources updated by name: container 0: cpu request; ephemeral container 0: cpu request",
+					},
+				},
+			},
+		},
+		{
+			name: "request:limit ratio policy scales the limit",
+			podJson: []byte(
+				`{
+					"spec": {
+						"containers": [
+							{
+								"resources": {
+									"requests": {
+										"cpu": "1"
+									},
+									"limits": {
+										"cpu": "2"
+									}
+								}
+							}
+						]
+					}
+				}`),
+			namespace: "default",
+			recommendResources: []ContainerResources{
+				{
+					Index: 0,
+					Requests: apiv1.ResourceList{
+						cpu: resource.MustParse("2"),
+					},
+					Limits: apiv1.ResourceList{
+						cpu: resource.MustParse("4"),
+					},
+				},
+			},
+			recommendAnnotations: vpa_api_util.ContainerToAnnotationsMap{},
+			recommendName:        "name",
+			expectPatches: []patchRecord{
+				addResourceRequestPatch(0, cpu, "2"),
+				{"add", "/spec/containers/0/resources/limits/cpu", resource.MustParse("4")},
+				{
+					"add",
+					"/metadata/annotations",
+					map[string]string{
+						"vpaUpdates": "Pod resources updated by name: container 0: cpu request, cpu limit",
+					},
+				},
+			},
+		},
+		{
+			name: "Job that already ran to completion is not patched",
+			podJson: []byte(
+				`{
+					"spec": {
+						"containers": [{}]
+					},
+					"status": {
+						"phase": "Succeeded"
+					}
+				}`),
+			namespace: "default",
+			recommendResources: []ContainerResources{
+				{
+					Index: 0,
+					Requests: apiv1.ResourceList{
+						cpu: resource.MustParse("1"),
+					},
+				},
+			},
+			recommendAnnotations:  vpa_api_util.ContainerToAnnotationsMap{},
+			recommendName:         "name",
+			recommendWorkloadKind: WorkloadKindJob,
+			expectPatches:         []patchRecord{},
+		},
+		{
+			// The DaemonSet floor itself is applied by recommendation_provider.go, before the
+			// resources ever reach here, so that ContainerResources.Limits is derived from the
+			// floored request rather than the pre-floor one. The server just patches whatever
+			// request the provider returns, for DaemonSet pods same as any other.
+			name: "DaemonSet recommendation is patched as returned by the provider",
+			podJson: []byte(
+				`{
+					"spec": {
+						"containers": [
+							{
+								"resources": {
+									"requests": {
+										"cpu": "2"
+									}
+								}
+							}
+						]
+					}
+				}`),
+			namespace: "default",
+			recommendResources: []ContainerResources{
+				{
+					Index: 0,
+					Requests: apiv1.ResourceList{
+						cpu: resource.MustParse("2"),
+					},
+				},
+			},
+			recommendAnnotations:  vpa_api_util.ContainerToAnnotationsMap{},
+			recommendName:         "name",
+			recommendWorkloadKind: WorkloadKindDaemonSet,
+			expectPatches: []patchRecord{
+				addResourceRequestPatch(0, cpu, "2"),
+				addAnnotationRequest([][]string{{cpu}}),
+			},
+		},
+		{
+			name: "StatefulSet member gets a distinct annotation shape",
+			podJson: []byte(
+				`{
+					"spec": {
+						"containers": [{}]
+					}
+				}`),
+			namespace: "default",
+			recommendResources: []ContainerResources{
+				{
+					Index: 0,
+					Requests: apiv1.ResourceList{
+						cpu: resource.MustParse("1"),
+					},
+				},
+			},
+			recommendAnnotations:  vpa_api_util.ContainerToAnnotationsMap{},
+			recommendName:         "name",
+			recommendWorkloadKind: WorkloadKindStatefulSet,
+			expectPatches: []patchRecord{
+				addResourcesPatch(0),
+				addRequestsPatch(0),
+				addResourceRequestPatch(0, cpu, "1"),
+				{
+					"add",
+					"/metadata/annotations",
+					map[string]string{
+						"vpaUpdates": "StatefulSet member pod resources updated by name: container 0: cpu request",
+					},
+				},
+			},
+		},
 	}
 	for _, tc := range tests {
 		t.Run(fmt.Sprintf("test case: %s", tc.name), func(t *testing.T) {
 			fppp := fakePodPreProcessor{e: tc.podPreProcessorError}
 			fvpp := fakeVpaPreProcessor{}
-			frp := fakeRecommendationProvider{tc.recommendResources, tc.recommendAnnotations, tc.recommendName, tc.recommendError}
-			s := NewAdmissionServer(&frp, &fppp, &fvpp)
+			frp := fakeRecommendationProvider{tc.recommendResources, tc.recommendAnnotations, tc.recommendName, tc.recommendWorkloadKind, tc.recommendError}
+			s := NewAdmissionServer(map[string]RecommendationProvider{defaultRecommenderName: &frp}, &fppp, &fvpp)
 			patches, err := s.getPatchesForPodResourceRequest(tc.podJson, tc.namespace)
 			if tc.expectError == nil {
 				assert.NoError(t, err)
@@ -271,7 +505,8 @@ func TestGetPatchesForResourceRequest_TwoReplacementResources(t *testing.T) {
 	fvpp := fakeVpaPreProcessor{}
 	recommendResources := []ContainerResources{
 		{
-			apiv1.ResourceList{
+			Index: 0,
+			Requests: apiv1.ResourceList{
 				cpu:        resource.MustParse("1"),
 				unobtanium: resource.MustParse("2"),
 			},
@@ -292,8 +527,8 @@ func TestGetPatchesForResourceRequest_TwoReplacementResources(t *testing.T) {
 					}
 				}`)
 	recommendAnnotations := vpa_api_util.ContainerToAnnotationsMap{}
-	frp := fakeRecommendationProvider{recommendResources, recommendAnnotations, "name", nil}
-	s := NewAdmissionServer(&frp, &fppp, &fvpp)
+	frp := fakeRecommendationProvider{recommendResources, recommendAnnotations, "name", "", nil}
+	s := NewAdmissionServer(map[string]RecommendationProvider{defaultRecommenderName: &frp}, &fppp, &fvpp)
 	patches, err := s.getPatchesForPodResourceRequest(podJson, "default")
 	assert.NoError(t, err)
 	// Order of updates for cpu and unobtanium depends on order of iterating a map, both possible results are valid.
@@ -313,10 +548,11 @@ func TestValidateVPA(t *testing.T) {
 	badScalingMode := vpa_types.ContainerScalingMode("bad")
 	validScalingMode := vpa_types.ContainerScalingModeAuto
 	tests := []struct {
-		name        string
-		vpa         vpa_types.VerticalPodAutoscaler
-		isCreate    bool
-		expectError error
+		name         string
+		vpa          vpa_types.VerticalPodAutoscaler
+		isCreate     bool
+		recommenders []string
+		expectError  error
 	}{
 		{
 			name: "empty update",
@@ -375,6 +611,22 @@ func TestValidateVPA(t *testing.T) {
 			},
 			expectError: fmt.Errorf("unexpected Mode value bad"),
 		},
+		{
+			name: "invalid limit scaling mode",
+			vpa: vpa_types.VerticalPodAutoscaler{
+				Spec: vpa_types.VerticalPodAutoscalerSpec{
+					ResourcePolicy: &vpa_types.PodResourcePolicy{
+						ContainerPolicies: []vpa_types.ContainerResourcePolicy{
+							{
+								ContainerName:    "loot box",
+								LimitScalingMode: limitScalingMode(vpa_types.LimitScalingMode("bad")),
+							},
+						},
+					},
+				},
+			},
+			expectError: fmt.Errorf("unexpected LimitScalingMode value bad"),
+		},
 		{
 			name: "bad limits",
 			vpa: vpa_types.VerticalPodAutoscaler{
@@ -420,10 +672,64 @@ func TestValidateVPA(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "unknown recommender name",
+			vpa: vpa_types.VerticalPodAutoscaler{
+				Spec: vpa_types.VerticalPodAutoscalerSpec{
+					Recommenders: []*vpa_types.VerticalPodAutoscalerRecommenderSelector{
+						{Name: "vpa-ml"},
+					},
+				},
+			},
+			recommenders: []string{defaultRecommenderName},
+			expectError:  fmt.Errorf("unknown recommender name vpa-ml"),
+		},
+		{
+			name: "more than one recommender is rejected even when the operator runs both",
+			vpa: vpa_types.VerticalPodAutoscaler{
+				Spec: vpa_types.VerticalPodAutoscalerSpec{
+					Recommenders: []*vpa_types.VerticalPodAutoscalerRecommenderSelector{
+						{Name: "vpa-conservative"},
+						{Name: "vpa-ml"},
+					},
+				},
+			},
+			recommenders: []string{"vpa-conservative", "vpa-ml"},
+			expectError:  fmt.Errorf("VPA  specifies 2 recommenders but only one recommender per VPA object is supported"),
+		},
+		{
+			name: "known recommender name, multi-recommender operator",
+			vpa: vpa_types.VerticalPodAutoscaler{
+				Spec: vpa_types.VerticalPodAutoscalerSpec{
+					Recommenders: []*vpa_types.VerticalPodAutoscalerRecommenderSelector{
+						{Name: "vpa-ml"},
+					},
+				},
+			},
+			recommenders: []string{"vpa-conservative", "vpa-ml"},
+		},
+		{
+			name: "null entry in recommenders does not panic",
+			vpa: vpa_types.VerticalPodAutoscaler{
+				Spec: vpa_types.VerticalPodAutoscalerSpec{
+					Recommenders: []*vpa_types.VerticalPodAutoscalerRecommenderSelector{nil},
+				},
+			},
+			recommenders: []string{defaultRecommenderName},
+		},
 	}
 	for _, tc := range tests {
 		t.Run(fmt.Sprintf("test case: %s", tc.name), func(t *testing.T) {
-			err := validateVPA(&tc.vpa, tc.isCreate)
+			recommenders := tc.recommenders
+			if len(recommenders) == 0 {
+				recommenders = []string{defaultRecommenderName}
+			}
+			providers := make(map[string]RecommendationProvider, len(recommenders))
+			for _, name := range recommenders {
+				providers[name] = &fakeRecommendationProvider{}
+			}
+			s := NewAdmissionServer(providers, &fakePodPreProcessor{}, &fakeVpaPreProcessor{})
+			err := s.validateVPA(&tc.vpa, tc.isCreate)
 			if tc.expectError == nil {
 				assert.NoError(t, err)
 			} else {
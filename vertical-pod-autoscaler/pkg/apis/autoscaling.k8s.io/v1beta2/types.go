@@ -0,0 +1,275 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	autoscaling "k8s.io/api/autoscaling/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VerticalPodAutoscaler is the configuration for a vertical pod
+// autoscaler, which automatically manages pod resources based on historical and
+// real-time resource utilization.
+type VerticalPodAutoscaler struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Specification of the behavior of the autoscaler.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status.
+	Spec VerticalPodAutoscalerSpec `json:"spec"`
+
+	// Current information about the autoscaler.
+	// +optional
+	Status VerticalPodAutoscalerStatus `json:"status,omitempty"`
+}
+
+// VerticalPodAutoscalerSpec is the specification of the behavior of the autoscaler.
+type VerticalPodAutoscalerSpec struct {
+	// TargetRef points to the controller managing the set of pods for the
+	// autoscaler to control - e.g. Deployment, StatefulSet.
+	TargetRef *autoscaling.CrossVersionObjectReference `json:"targetRef"`
+
+	// UpdatePolicy describes the rules on how changes are applied to the pods.
+	// If not specified, all fields in the PodUpdatePolicy are set to their
+	// default values.
+	// +optional
+	UpdatePolicy *PodUpdatePolicy `json:"updatePolicy,omitempty"`
+
+	// ResourcePolicy controls how the autoscaler computes the recommended
+	// resources for containers belonging to the pod. If not specified, the
+	// autoscaler computes recommended resources for all containers in the pod,
+	// without additional preferences.
+	// +optional
+	ResourcePolicy *PodResourcePolicy `json:"resourcePolicy,omitempty"`
+
+	// Recommenders is a list of recommender components this VPA object should
+	// use for recommendations. When empty, the default recommender is used.
+	// Only one recommender per VPA object is supported for now.
+	// +optional
+	// +patchMergeKey=name
+	// +patchStrategy=merge
+	Recommenders []*VerticalPodAutoscalerRecommenderSelector `json:"recommenders,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+}
+
+// VerticalPodAutoscalerRecommenderSelector points to a specific Vertical Pod
+// Autoscaler recommender. In the future it might pass parameters to the
+// recommender.
+type VerticalPodAutoscalerRecommenderSelector struct {
+	// Name of the recommender responsible for generating recommendation for this object.
+	Name string `json:"name"`
+}
+
+// PodUpdatePolicy describes the rules on how changes are applied to the pods.
+type PodUpdatePolicy struct {
+	// Controls when autoscaler applies changes to the pod resources.
+	// The default is 'Auto'.
+	// +optional
+	UpdateMode *UpdateMode `json:"updateMode,omitempty"`
+}
+
+// UpdateMode controls when autoscaler applies changes to the pod resources.
+type UpdateMode string
+
+const (
+	// UpdateModeOff means that autoscaler never changes Pod resources.
+	// The recommender still sets the recommended resources in the
+	// VerticalPodAutoscaler object, it can be used e.g. for status quo logging.
+	UpdateModeOff UpdateMode = "Off"
+	// UpdateModeInitial means that autoscaler only assigns resources on pod
+	// creation and does not change them during the lifetime of the pod.
+	UpdateModeInitial UpdateMode = "Initial"
+	// UpdateModeRecreate means that autoscaler assigns resources on pod
+	// creation and additionally can update them during the lifetime of the
+	// pod by deleting and recreating the pod.
+	UpdateModeRecreate UpdateMode = "Recreate"
+	// UpdateModeAuto means that autoscaler assigns resources on pod creation
+	// and additionally can update them during the lifetime of the pod,
+	// using any available update method. Currently this is equivalent to
+	// Recreate, which is the only available update method.
+	UpdateModeAuto UpdateMode = "Auto"
+)
+
+// PodResourcePolicy controls how autoscaler computes the recommended resources
+// for containers belonging to the pod. There can be at most one entry for
+// every named container and optionally a single wildcard entry with
+// `containerName` = '*', which handles all containers that don't have
+// individual policies.
+type PodResourcePolicy struct {
+	// Per-container resource policies.
+	// +optional
+	// +patchMergeKey=containerName
+	// +patchStrategy=merge
+	ContainerPolicies []ContainerResourcePolicy `json:"containerPolicies,omitempty" patchStrategy:"merge" patchMergeKey:"containerName"`
+}
+
+// ContainerResourcePolicy controls how autoscaler computes the recommended
+// resources for a specific container.
+type ContainerResourcePolicy struct {
+	// Name of the container or DefaultContainerResourcePolicy, in which case
+	// the policy is used by the containers that don't have their own policy
+	// specified.
+	ContainerName string `json:"containerName,omitempty"`
+	// Whether autoscaler is enabled for the container. The default is "Auto".
+	// +optional
+	Mode *ContainerScalingMode `json:"mode,omitempty"`
+	// Specifies the minimal amount of resources that will be recommended for
+	// the container. The default is no minimum.
+	// +optional
+	MinAllowed v1.ResourceList `json:"minAllowed,omitempty"`
+	// Specifies the maximum amount of resources that will be recommended for
+	// the container. The default is no maximum.
+	// +optional
+	MaxAllowed v1.ResourceList `json:"maxAllowed,omitempty"`
+	// Specifies the type of recommendations that will be computed
+	// (and possibly applied) by VPA.
+	// +optional
+	ControlledResources *[]v1.ResourceName `json:"controlledResources,omitempty"`
+	// LimitScalingMode specifies how the container's resource limits, if any,
+	// should be scaled when the admission controller patches its requests.
+	// The default is "Preserve", under which limits are left untouched.
+	// +optional
+	LimitScalingMode *LimitScalingMode `json:"limitScalingMode,omitempty"`
+}
+
+// ContainerScalingMode controls whether autoscaler is enabled for a specific
+// container.
+type ContainerScalingMode string
+
+const (
+	// ContainerScalingModeAuto means autoscaling is enabled for a container.
+	ContainerScalingModeAuto ContainerScalingMode = "Auto"
+	// ContainerScalingModeOff means autoscaling is disabled for a container.
+	ContainerScalingModeOff ContainerScalingMode = "Off"
+)
+
+// LimitScalingMode controls how a container's resource limits are scaled
+// relative to its recommended request when the admission controller patches
+// the pod, for containers that already specify a limit for the resource.
+type LimitScalingMode string
+
+const (
+	// LimitScalingModePreserve means the container's existing limits are left
+	// untouched, regardless of how its requests change. This is the default.
+	LimitScalingModePreserve LimitScalingMode = "Preserve"
+	// LimitScalingModeMatchRequest means the container's limit is always set
+	// equal to its recommended request.
+	LimitScalingModeMatchRequest LimitScalingMode = "MatchRequest"
+	// LimitScalingModeProportionalToRequest means the container's limit is
+	// scaled by the same ratio as its request, preserving the request:limit
+	// ratio it had before the recommendation was applied.
+	LimitScalingModeProportionalToRequest LimitScalingMode = "ProportionalToRequest"
+)
+
+// VerticalPodAutoscalerStatus describes the runtime information of the
+// autoscaler, as computed and reported by a recommender.
+type VerticalPodAutoscalerStatus struct {
+	// Recommendation is the current recommendation computed by the autoscaler.
+	// +optional
+	Recommendation *RecommendedPodResources `json:"recommendation,omitempty"`
+
+	// Conditions is the set of conditions required for this autoscaler to
+	// scale its target, and indicates whether or not those conditions are met.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []VerticalPodAutoscalerCondition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// RecommendedPodResources is the recommendation of resources computed by
+// autoscaler. It contains a recommendation for each container in the pod
+// (except for those with `ContainerScalingMode` set to 'Off').
+type RecommendedPodResources struct {
+	// Resources recommended by the autoscaler for each container.
+	// +optional
+	ContainerRecommendations []RecommendedContainerResources `json:"containerRecommendations,omitempty"`
+}
+
+// RecommendedContainerResources is the recommendation of resources computed
+// by autoscaler for a specific container. Respects the container resource
+// policy if present in the spec. In particular, the recommendation is not
+// produced for containers with `ContainerScalingMode` set to 'Off'.
+type RecommendedContainerResources struct {
+	// Name of the container.
+	ContainerName string `json:"containerName,omitempty"`
+	// Recommended amount of resources. Observes ContainerResourcePolicy.
+	Target v1.ResourceList `json:"target"`
+	// Minimum recommended amount of resources. All values should be equal
+	// or lower than those for Target.
+	// +optional
+	LowerBound v1.ResourceList `json:"lowerBound,omitempty"`
+	// Maximum recommended amount of resources. All values should be equal
+	// or greater than those for Target.
+	// +optional
+	UpperBound v1.ResourceList `json:"upperBound,omitempty"`
+	// The most recent recommended amount of resources, ignoring bounds
+	// enforced by the ContainerResourcePolicy.
+	// +optional
+	UncappedTarget v1.ResourceList `json:"uncappedTarget,omitempty"`
+}
+
+// VerticalPodAutoscalerConditionType are the valid conditions of a
+// VerticalPodAutoscaler.
+type VerticalPodAutoscalerConditionType string
+
+var (
+	// RecommendationProvided indicates whether the VPA recommender was able
+	// to calculate a recommendation.
+	RecommendationProvided VerticalPodAutoscalerConditionType = "RecommendationProvided"
+	// LowConfidence indicates whether the VPA recommender has low confidence
+	// in the recommendation for some of containers.
+	LowConfidence VerticalPodAutoscalerConditionType = "LowConfidence"
+	// NoPodsMatched indicates that label selector used with VPA object
+	// didn't match any pods.
+	NoPodsMatched VerticalPodAutoscalerConditionType = "NoPodsMatched"
+	// FetchingHistory indicates that VPA recommender is in the process of
+	// loading additional history samples.
+	FetchingHistory VerticalPodAutoscalerConditionType = "FetchingHistory"
+	// ConfigDeprecated indicates that this VPA configuration is deprecated
+	// and will stop being supported soon.
+	ConfigDeprecated VerticalPodAutoscalerConditionType = "ConfigDeprecated"
+	// ConfigUnsupported indicates that this VPA configuration is
+	// unsupported and recommendations will not be provided for it.
+	ConfigUnsupported VerticalPodAutoscalerConditionType = "ConfigUnsupported"
+)
+
+// VerticalPodAutoscalerCondition describes the state of a VerticalPodAutoscaler at a
+// certain point.
+type VerticalPodAutoscalerCondition struct {
+	// type describes the current condition
+	Type VerticalPodAutoscalerConditionType `json:"type"`
+	// status is the status of the condition (True, False, Unknown)
+	Status v1.ConditionStatus `json:"status"`
+	// lastTransitionTime is the last time the condition transitioned from
+	// one status to another
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// reason is the reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// message is a human-readable explanation containing details about
+	// the transition
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// VerticalPodAutoscalerList is a list of VerticalPodAutoscaler objects.
+type VerticalPodAutoscalerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VerticalPodAutoscaler `json:"items"`
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package target
+
+import (
+	"fmt"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	scaleclient "k8s.io/client-go/scale"
+	"k8s.io/klog"
+
+	vpa_types "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1beta2"
+)
+
+// VpaTargetSelectorFetcher gets a labelSelector used to gather pods controlled by the given VPA,
+// along with the Kind of the workload object the VPA's TargetRef ultimately resolves to (e.g.
+// "Deployment", "StatefulSet", "DaemonSet"), as resolved by following any intermediate
+// owner references down to the first well-known, scalable controller.
+type VpaTargetSelectorFetcher interface {
+	// Fetch returns a labelSelector used to gather pods controlled by the given VPA, and the
+	// Kind of the workload the VPA targets. The returned Kind is empty if it could not be
+	// resolved, but the error is nil as long as a selector was still found.
+	Fetch(vpa *vpa_types.VerticalPodAutoscaler) (labels.Selector, string, error)
+}
+
+// NewVpaTargetSelectorFetcher returns a new instance of VpaTargetSelectorFetcher that resolves
+// a VPA's target through the scale subresource of the RESTMapper-resolved target kind.
+func NewVpaTargetSelectorFetcher(scaleNamespacer scaleclient.ScalesGetter, mapper apimeta.RESTMapper) VpaTargetSelectorFetcher {
+	return &targetSelectorFetcher{
+		scaleNamespacer: scaleNamespacer,
+		mapper:          mapper,
+	}
+}
+
+type targetSelectorFetcher struct {
+	scaleNamespacer scaleclient.ScalesGetter
+	mapper          apimeta.RESTMapper
+}
+
+func (f *targetSelectorFetcher) Fetch(vpa *vpa_types.VerticalPodAutoscaler) (labels.Selector, string, error) {
+	if vpa.Spec.TargetRef == nil {
+		return nil, "", fmt.Errorf("targetRef not defined for VPA object %v/%v", vpa.Namespace, vpa.Name)
+	}
+	groupKind, err := schema.ParseGroupKind(vpa.Spec.TargetRef.Kind)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not parse targetRef kind %v: %v", vpa.Spec.TargetRef.Kind, err)
+	}
+	groupKind.Group = inferGroup(vpa.Spec.TargetRef.APIVersion, groupKind.Group)
+
+	mappings, err := f.mapper.RESTMappings(groupKind)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to resolve controller %v/%v: %v", vpa.Namespace, vpa.Spec.TargetRef.Name, err)
+	}
+
+	var lastErr error
+	for _, mapping := range mappings {
+		groupResource := mapping.Resource.GroupResource()
+		scale, err := f.scaleNamespacer.Scales(vpa.Namespace).Get(groupResource, vpa.Spec.TargetRef.Name)
+		if err == nil {
+			selector, err := labels.Parse(scale.Status.Selector)
+			if err != nil {
+				return nil, "", fmt.Errorf("unable to parse selector from scale subresource for %v/%v: %v", vpa.Namespace, vpa.Spec.TargetRef.Name, err)
+			}
+			return selector, groupKind.Kind, nil
+		}
+		lastErr = err
+	}
+	klog.V(4).Infof("unable to fetch selector for VPA object %v/%v through the scale subresource: %v", vpa.Namespace, vpa.Name, lastErr)
+	return nil, "", fmt.Errorf("unable to read selector for %v/%v: %v", vpa.Namespace, vpa.Spec.TargetRef.Name, lastErr)
+}
+
+// inferGroup fills in the API group implied by apiVersion when the targetRef itself didn't
+// specify one, since CrossVersionObjectReference.Kind alone doesn't carry it.
+func inferGroup(apiVersion, group string) string {
+	if group != "" {
+		return group
+	}
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return group
+	}
+	return gv.Group
+}